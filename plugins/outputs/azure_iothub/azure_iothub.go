@@ -7,26 +7,56 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
 	iothub "github.com/amenzhinsky/iothub/iotdevice"
 	iotmqtt "github.com/amenzhinsky/iothub/iotdevice/transport/mqtt"
 	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/config"
 	"github.com/influxdata/telegraf/plugins/outputs"
 	"github.com/influxdata/telegraf/plugins/serializers"
+	"github.com/influxdata/telegraf/selfstat"
 )
 
 // Iothub struct
 type Iothub struct {
 	Client              *iothub.ModuleClient
-	UseGateway          bool   `toml:"use_gateway"`
-	ConnectionString    string `toml:"connection_string"`
-	HubName             string `toml:"hub_name"`
-	DeviceID            string `toml:"device_id"`
-	ModuleID            string `toml:"module_id"`
-	SharedAccessKey     string `toml:"shared_access_key"`
-	SharedAccessKeyName string `toml:"shared_access_key_name"`
-	serializer          serializers.Serializer
+	UseGateway          bool         `toml:"use_gateway"`
+	ConnectionString    string       `toml:"connection_string"`
+	HubName             string       `toml:"hub_name"`
+	DeviceID            string       `toml:"device_id"`
+	ModuleID            string       `toml:"module_id"`
+	SharedAccessKey     string       `toml:"shared_access_key"`
+	SharedAccessKeyName string       `toml:"shared_access_key_name"`
+	Endpoints           []Endpoint   `toml:"endpoint"`
+	Enrichments         []Enrichment `toml:"enrichment"`
+
+	UploadMode              string          `toml:"upload_mode"`
+	FileUploadContainer     string          `toml:"file_upload_container"`
+	FileUploadMaxBytes      int64           `toml:"file_upload_max_bytes"`
+	FileUploadFlushInterval config.Duration `toml:"file_upload_flush_interval"`
+	FileUploadCompression   bool            `toml:"file_upload_compression"`
+
+	ConnectTimeout     config.Duration `toml:"connect_timeout"`
+	WriteTimeout       config.Duration `toml:"write_timeout"`
+	MaxBufferedMetrics int             `toml:"max_buffered_metrics"`
+
+	EnableDirectMethods bool `toml:"enable_direct_methods"`
+	EnableTwinSync      bool `toml:"enable_twin_sync"`
+
+	Log telegraf.Logger `toml:"-"`
+
+	twin              twinCache
+	fileUpload        fileUploadBuffer
+	conn              connState
+	control           controlState
+	controlMu         sync.RWMutex
+	paused            int32
+	sentCounter       selfstat.Stat
+	droppedCounter    selfstat.Stat
+	reconnectsCounter selfstat.Stat
+	serializer        serializers.Serializer
 }
 
 // Description returns plugin description
@@ -57,6 +87,52 @@ func (i *Iothub) SampleConfig() string {
 	#  module_id = ""
 	#  shared_access_key = ""
 	#  use_gateway = true
+	#
+	## Route metrics to additional Event Hub / Service Bus / Storage endpoints
+	## instead of (or in addition to) the default IoT Hub telemetry path.
+	## Metrics matching no endpoint condition fall back to the default path.
+	#
+	# [[outputs.azure_iothub.endpoint]]
+	#   name = "cold-storage"
+	#   type = "storage_container"
+	#   connection_string = ""
+	#   container_name = ""
+	#   condition = "name == 'temperature'"
+
+	## Inject extra application properties into outbound messages so
+	## downstream routing/consumers see device-context fields without every
+	## metric carrying them as tags. value supports the literal tokens
+	## {device_id}, {module_id}, {hub_name}, and {iothub.twin.tag.<name>}.
+	#
+	# [[outputs.azure_iothub.enrichment]]
+	#   key = "iothub-connection-device-id"
+	#   value = "{device_id}"
+	#   # applies_to_endpoints = ["cold-storage"]
+
+	## Instead of publishing every batch over MQTT, buffer metrics in memory
+	## and ship them through IoT Hub's file upload feature. Useful for
+	## high-cardinality telemetry that would exceed the 256 KB D2C limit.
+	#
+	# upload_mode = "file"
+	# file_upload_container = ""
+	# file_upload_max_bytes = 4194304
+	# file_upload_flush_interval = "30s"
+	# file_upload_compression = false
+
+	## Connection lifecycle tuning.
+	#
+	# connect_timeout = "30s"
+	# write_timeout = "30s"
+	# max_buffered_metrics = 10000
+
+	## Let an operator retune this running module from the Azure portal or
+	## IoT Hub SDK: direct methods (flush, set_log_level, pause, resume) and
+	## twin desired-property updates (flush_interval, endpoint_routing).
+	## set_log_level only logs the requested level; it does not change the
+	## plugin's log level.
+	#
+	# enable_direct_methods = false
+	# enable_twin_sync = false
 `
 }
 
@@ -165,7 +241,7 @@ func (i *Iothub) Init() error {
 
 	if valid {
 
-		// if there's no explict connection string given
+		// if there's no explicit connection string given
 		if !i.hasConnectionString() {
 			// create connection string from IoT Hub configuration
 			i.createConnectionString()
@@ -191,6 +267,11 @@ func (i *Iothub) Init() error {
 			return err
 		}
 		i.serializer = s
+		i.initSelfStats()
+
+		if err := i.initEndpoints(); err != nil {
+			return err
+		}
 
 		return err
 	} else {
@@ -211,39 +292,211 @@ func (i *Iothub) Init() error {
 			return err
 		}
 		i.serializer = s
+		i.initSelfStats()
+
+		if err := i.initEndpoints(); err != nil {
+			return err
+		}
 
 		return err
 	}
 }
 
-var connected = false
-
-// Connect IoT Hub Client
-func (i *Iothub) Connect() error {
-	if !connected {
-		err := i.Client.Connect(context.Background())
-		if err != nil {
-			connected = true
+// initEndpoints resolves the downstream client and compiles the route
+// condition for every configured `[[outputs.azure_iothub.endpoint]]`, then
+// starts the module twin refresh goroutine if any enrichment needs it.
+func (i *Iothub) initEndpoints() error {
+	for idx := range i.Endpoints {
+		if err := i.Endpoints[idx].init(); err != nil {
+			return err
 		}
-		return err
 	}
-	return nil
+
+	if i.needsTwinSync() {
+		i.startTwinSync()
+	}
+
+	if i.usesFileUpload() {
+		i.startFileUploadFlusher()
+	}
+
+	return i.startControlPlane()
+}
+
+// usesFileUpload reports whether the plugin should ship batches through IoT
+// Hub's file upload feature instead of publishing them over MQTT.
+func (i *Iothub) usesFileUpload() bool {
+	return strings.EqualFold(strings.TrimSpace(i.UploadMode), "file")
+}
+
+// Connect establishes the IoT Hub MQTT connection if not already connected.
+// Connection state lives on the struct (see connState in connection.go) so
+// that multiple plugin instances don't share a single flag.
+func (i *Iothub) Connect() error {
+	return i.connect()
 }
 
 // Close IoT Hub Client connection
 func (i *Iothub) Close() error {
+	if i.twin.cancel != nil {
+		i.twin.cancel()
+	}
+
+	if i.fileUpload.cancel != nil {
+		i.fileUpload.cancel()
+		if err := i.flushFileUpload(context.Background()); err != nil {
+			i.Log.Errorf("azure_iothub: final file upload flush failed: %v", err)
+		}
+	}
+
+	if i.conn.cancel != nil {
+		i.conn.cancel()
+	}
+
+	if i.control.cancel != nil {
+		i.control.cancel()
+	}
+
 	err := i.Client.Close()
+
+	for idx := range i.Endpoints {
+		if e := i.Endpoints[idx].sender.Close(context.Background()); e != nil {
+			err = e
+		}
+	}
+
 	return err
 }
 
-// Write Telegraf metrics to IoT Hub
+// Write Telegraf metrics to IoT Hub. On a transport error the metrics are
+// buffered and a background reconnect-with-backoff loop is started; they
+// are re-sent once the connection recovers (see handleTransportError).
 func (i *Iothub) Write(metrics []telegraf.Metric) error {
+	if i.isPaused() {
+		return nil
+	}
+
+	if err := i.connect(); err != nil {
+		return err
+	}
+
+	return i.send(metrics)
+}
+
+// send dispatches metrics to the configured write path, buffering whatever
+// fails for the reconnect loop to retry. reconnectLoop also calls this
+// directly to resend buffered metrics once reconnected, bypassing Write's
+// pause gate: that resend is recovering data Write() already accepted, not
+// performing a fresh write, so pausing the output must not drop it.
+func (i *Iothub) send(metrics []telegraf.Metric) error {
+	var err error
+	failed := metrics
+	switch {
+	case i.usesFileUpload():
+		err = i.writeFile(metrics)
+	case len(i.Endpoints) > 0:
+		failed, err = i.writeRouted(metrics)
+	default:
+		err = i.writeDefault(metrics)
+	}
+
+	if err != nil {
+		i.handleTransportError(failed)
+		return err
+	}
 
+	i.sentCounter.Incr(int64(len(metrics)))
+	return nil
+}
+
+// writeDefault serializes metrics and sends them to the default IoT Hub
+// telemetry endpoint.
+func (i *Iothub) writeDefault(metrics []telegraf.Metric) error {
 	b, err := i.serializer.SerializeBatch(metrics)
-	if err == nil {
-		err = i.Client.SendEvent(context.Background(), b)
+	if err != nil {
+		return err
 	}
-	return err
+
+	ctx, cancel := context.WithTimeout(context.Background(), i.writeTimeout())
+	defer cancel()
+
+	return i.sendEvent(ctx, b, i.enrichmentProperties(""))
+}
+
+// sendEvent publishes a serialized batch to the default IoT Hub telemetry
+// endpoint, attaching any enriched application properties.
+func (i *Iothub) sendEvent(ctx context.Context, payload []byte, properties map[string]string) error {
+	opts := make([]iothub.SendOption, 0, len(properties))
+	for k, v := range properties {
+		opts = append(opts, iothub.WithSendProperty(k, v))
+	}
+	return i.Client.SendEvent(ctx, payload, opts...)
+}
+
+// writeRouted dispatches each metric to the first endpoint whose condition
+// matches it, falling back to the default IoT Hub telemetry path for
+// anything left unmatched. Each destination is sent independently: a
+// failure sending to one endpoint does not cause metrics already delivered
+// to another endpoint to be resent. writeRouted returns only the metrics
+// that failed to send, plus the first error encountered.
+func (i *Iothub) writeRouted(metrics []telegraf.Metric) ([]telegraf.Metric, error) {
+	grouped := make(map[*Endpoint][]telegraf.Metric)
+	var fallback []telegraf.Metric
+
+	for _, m := range metrics {
+		if ep := i.routeFor(m); ep != nil {
+			grouped[ep] = append(grouped[ep], m)
+		} else {
+			fallback = append(fallback, m)
+		}
+	}
+
+	var failed []telegraf.Metric
+	var firstErr error
+
+	for ep, ms := range grouped {
+		b, err := i.serializer.SerializeBatch(ms)
+		if err == nil {
+			err = ep.sender.Send(context.Background(), b, i.enrichmentProperties(ep.Name))
+		}
+		if err != nil {
+			failed = append(failed, ms...)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+
+	if len(fallback) > 0 {
+		b, err := i.serializer.SerializeBatch(fallback)
+		if err == nil {
+			err = i.sendEvent(context.Background(), b, i.enrichmentProperties(""))
+		}
+		if err != nil {
+			failed = append(failed, fallback...)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+
+	return failed, firstErr
+}
+
+// routeFor returns the first configured endpoint whose condition matches m,
+// or nil if none match and the metric should use the default telemetry path.
+// Guarded by controlMu since a twin desired-property update can rewrite
+// endpoint conditions concurrently (see applyDesiredProperties).
+func (i *Iothub) routeFor(m telegraf.Metric) *Endpoint {
+	i.controlMu.RLock()
+	defer i.controlMu.RUnlock()
+
+	for idx := range i.Endpoints {
+		if i.Endpoints[idx].condition.matches(m) {
+			return &i.Endpoints[idx]
+		}
+	}
+	return nil
 }
 
 func init() {
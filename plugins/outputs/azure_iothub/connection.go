@@ -0,0 +1,182 @@
+package azure_iothub
+
+// connection.go
+//
+// Connection lifecycle management: connect/reconnect state and timeouts.
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/selfstat"
+)
+
+const (
+	defaultConnectTimeout = 30 * time.Second
+	defaultWriteTimeout   = 30 * time.Second
+	minReconnectBackoff   = time.Second
+	maxReconnectBackoff   = time.Minute
+
+	defaultMaxBufferedMetrics = 10000
+)
+
+// connState guards the plugin's connected flag and the bounded buffer of
+// metrics produced while disconnected.
+type connState struct {
+	mu           sync.Mutex
+	connected    bool
+	reconnecting bool
+	buffered     []telegraf.Metric
+	cancel       context.CancelFunc
+}
+
+// initSelfStats registers the sent/dropped/reconnects counters exposed via
+// Telegraf's selfstat package, tagged by hub and device so multiple
+// instances of this plugin don't collide.
+func (i *Iothub) initSelfStats() {
+	tags := map[string]string{"hub_name": i.HubName, "device_id": i.DeviceID}
+	i.sentCounter = selfstat.Register("azure_iothub", "sent", tags)
+	i.droppedCounter = selfstat.Register("azure_iothub", "dropped", tags)
+	i.reconnectsCounter = selfstat.Register("azure_iothub", "reconnects", tags)
+}
+
+func (i *Iothub) connectTimeout() time.Duration {
+	if i.ConnectTimeout > 0 {
+		return time.Duration(i.ConnectTimeout)
+	}
+	return defaultConnectTimeout
+}
+
+func (i *Iothub) writeTimeout() time.Duration {
+	if i.WriteTimeout > 0 {
+		return time.Duration(i.WriteTimeout)
+	}
+	return defaultWriteTimeout
+}
+
+func (i *Iothub) maxBufferedMetrics() int {
+	if i.MaxBufferedMetrics > 0 {
+		return i.MaxBufferedMetrics
+	}
+	return defaultMaxBufferedMetrics
+}
+
+// connect establishes the MQTT connection to IoT Hub if not already
+// connected. Safe to call on every Write(); it is a no-op once connected.
+func (i *Iothub) connect() error {
+	i.conn.mu.Lock()
+	if i.conn.connected {
+		i.conn.mu.Unlock()
+		return nil
+	}
+	i.conn.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), i.connectTimeout())
+	defer cancel()
+
+	if err := i.Client.Connect(ctx); err != nil {
+		return err
+	}
+
+	i.conn.mu.Lock()
+	i.conn.connected = true
+	i.conn.mu.Unlock()
+
+	return nil
+}
+
+// trimBuffered appends incoming to buffered, evicting the oldest entries
+// once max is exceeded. max <= 0 means unbounded. Returns the resulting
+// buffer and how many entries were evicted.
+func trimBuffered(buffered, incoming []telegraf.Metric, max int) ([]telegraf.Metric, int) {
+	buffered = append(buffered, incoming...)
+	if max <= 0 || len(buffered) <= max {
+		return buffered, 0
+	}
+
+	dropped := len(buffered) - max
+	return buffered[dropped:], dropped
+}
+
+// nextBackoff doubles current, capped at maxReconnectBackoff.
+func nextBackoff(current time.Duration) time.Duration {
+	next := current * 2
+	if next > maxReconnectBackoff {
+		next = maxReconnectBackoff
+	}
+	return next
+}
+
+// handleTransportError marks the connection down, buffers the metrics that
+// failed to send (dropping the oldest once max_buffered_metrics is
+// exceeded), and kicks off a background reconnect loop if one isn't already
+// running. The loop's context is stored on conn.cancel so Close() can stop
+// it instead of leaving it retrying forever against a closed client.
+func (i *Iothub) handleTransportError(metrics []telegraf.Metric) {
+	i.conn.mu.Lock()
+	i.conn.connected = false
+
+	var dropped int
+	i.conn.buffered, dropped = trimBuffered(i.conn.buffered, metrics, i.maxBufferedMetrics())
+	if dropped > 0 {
+		i.droppedCounter.Incr(int64(dropped))
+	}
+
+	alreadyReconnecting := i.conn.reconnecting
+	var ctx context.Context
+	if !alreadyReconnecting {
+		i.conn.reconnecting = true
+		ctx, i.conn.cancel = context.WithCancel(context.Background())
+	}
+	i.conn.mu.Unlock()
+
+	if !alreadyReconnecting {
+		go i.reconnectLoop(ctx)
+	}
+}
+
+// reconnectLoop retries connect() with exponential backoff until it
+// succeeds or ctx is canceled by Close(), then re-sends whatever metrics
+// were buffered while disconnected.
+func (i *Iothub) reconnectLoop(ctx context.Context) {
+	backoff := minReconnectBackoff
+
+	for {
+		if ctx.Err() != nil {
+			return
+		}
+
+		if err := i.connect(); err != nil {
+			i.Log.Errorf("azure_iothub: reconnect failed, retrying in %s: %v", backoff, err)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+
+			backoff = nextBackoff(backoff)
+			continue
+		}
+
+		i.reconnectsCounter.Incr(1)
+
+		i.conn.mu.Lock()
+		buffered := i.conn.buffered
+		i.conn.buffered = nil
+		i.conn.reconnecting = false
+		i.conn.mu.Unlock()
+
+		if len(buffered) > 0 {
+			// send, not Write: resending already-accepted data must not be
+			// silently dropped if the output happens to be paused.
+			if err := i.send(buffered); err != nil {
+				i.Log.Errorf("azure_iothub: failed to re-send buffered metrics after reconnect: %v", err)
+			}
+		}
+
+		return
+	}
+}
@@ -0,0 +1,98 @@
+package azure_iothub
+
+import (
+	"testing"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/config"
+	"github.com/influxdata/telegraf/metric"
+)
+
+func TestConnectTimeout(t *testing.T) {
+	i := &Iothub{}
+	if got := i.connectTimeout(); got != defaultConnectTimeout {
+		t.Errorf("connectTimeout() with no override = %s, want default %s", got, defaultConnectTimeout)
+	}
+
+	i.ConnectTimeout = config.Duration(5 * time.Second)
+	if got := i.connectTimeout(); got != 5*time.Second {
+		t.Errorf("connectTimeout() = %s, want 5s", got)
+	}
+}
+
+func TestWriteTimeout(t *testing.T) {
+	i := &Iothub{}
+	if got := i.writeTimeout(); got != defaultWriteTimeout {
+		t.Errorf("writeTimeout() with no override = %s, want default %s", got, defaultWriteTimeout)
+	}
+
+	i.WriteTimeout = config.Duration(5 * time.Second)
+	if got := i.writeTimeout(); got != 5*time.Second {
+		t.Errorf("writeTimeout() = %s, want 5s", got)
+	}
+}
+
+func newTestMetric(name string) telegraf.Metric {
+	m, err := metric.New(name, nil, map[string]interface{}{"value": 1}, time.Unix(0, 0))
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+func TestTrimBuffered(t *testing.T) {
+	existing := []telegraf.Metric{newTestMetric("a"), newTestMetric("b")}
+	incoming := []telegraf.Metric{newTestMetric("c")}
+
+	t.Run("unbounded", func(t *testing.T) {
+		got, dropped := trimBuffered(existing, incoming, 0)
+		if dropped != 0 {
+			t.Errorf("dropped = %d, want 0", dropped)
+		}
+		if len(got) != 3 {
+			t.Errorf("len(buffered) = %d, want 3", len(got))
+		}
+	})
+
+	t.Run("within max", func(t *testing.T) {
+		got, dropped := trimBuffered(existing, incoming, 3)
+		if dropped != 0 {
+			t.Errorf("dropped = %d, want 0", dropped)
+		}
+		if len(got) != 3 {
+			t.Errorf("len(buffered) = %d, want 3", len(got))
+		}
+	})
+
+	t.Run("evicts oldest once max exceeded", func(t *testing.T) {
+		got, dropped := trimBuffered(existing, incoming, 2)
+		if dropped != 1 {
+			t.Errorf("dropped = %d, want 1", dropped)
+		}
+		if len(got) != 2 {
+			t.Fatalf("len(buffered) = %d, want 2", len(got))
+		}
+		if got[0].Name() != "b" || got[1].Name() != "c" {
+			t.Errorf("buffered = %v, want [b c] (oldest evicted first)", got)
+		}
+	})
+}
+
+func TestNextBackoff(t *testing.T) {
+	tests := []struct {
+		current time.Duration
+		want    time.Duration
+	}{
+		{current: time.Second, want: 2 * time.Second},
+		{current: 30 * time.Second, want: time.Minute},
+		{current: time.Minute, want: maxReconnectBackoff},
+		{current: 2 * time.Minute, want: maxReconnectBackoff},
+	}
+
+	for _, tt := range tests {
+		if got := nextBackoff(tt.current); got != tt.want {
+			t.Errorf("nextBackoff(%s) = %s, want %s", tt.current, got, tt.want)
+		}
+	}
+}
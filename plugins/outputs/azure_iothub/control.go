@@ -0,0 +1,153 @@
+package azure_iothub
+
+// control.go
+//
+// Control plane: direct method handlers and twin desired-property driven reconfiguration.
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	iothub "github.com/amenzhinsky/iothub/iotdevice"
+	"github.com/influxdata/telegraf/config"
+)
+
+// controlState holds the cancel func for the desired-property sync
+// goroutine started by startDesiredPropertySync.
+type controlState struct {
+	cancel context.CancelFunc
+}
+
+// startControlPlane registers direct method handlers and/or subscribes to
+// twin desired-property updates, per enable_direct_methods/enable_twin_sync.
+func (i *Iothub) startControlPlane() error {
+	if i.EnableDirectMethods {
+		if err := i.registerDirectMethods(); err != nil {
+			return err
+		}
+	}
+
+	if i.EnableTwinSync {
+		i.startDesiredPropertySync()
+	}
+
+	return nil
+}
+
+func (i *Iothub) registerDirectMethods() error {
+	ctx := context.Background()
+
+	handlers := map[string]iothub.DirectMethodHandler{
+		"flush":         i.handleFlushMethod,
+		"set_log_level": i.handleSetLogLevelMethod,
+		"pause":         i.handlePauseMethod,
+		"resume":        i.handleResumeMethod,
+	}
+
+	for name, handler := range handlers {
+		if err := i.Client.RegisterMethod(ctx, name, handler); err != nil {
+			return fmt.Errorf("registering direct method %q: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+func (i *Iothub) handleFlushMethod(map[string]interface{}) (map[string]interface{}, error) {
+	if err := i.flushFileUpload(context.Background()); err != nil {
+		return map[string]interface{}{"success": false, "error": err.Error()}, nil
+	}
+	return map[string]interface{}{"success": true}, nil
+}
+
+// handleSetLogLevelMethod logs the requested level and reports success; it
+// does not change the plugin's log level.
+func (i *Iothub) handleSetLogLevelMethod(p map[string]interface{}) (map[string]interface{}, error) {
+	level, _ := p["level"].(string)
+	i.Log.Infof("azure_iothub: log level change requested via direct method: %s", level)
+	return map[string]interface{}{"success": true}, nil
+}
+
+func (i *Iothub) handlePauseMethod(map[string]interface{}) (map[string]interface{}, error) {
+	atomic.StoreInt32(&i.paused, 1)
+	return map[string]interface{}{"success": true}, nil
+}
+
+func (i *Iothub) handleResumeMethod(map[string]interface{}) (map[string]interface{}, error) {
+	atomic.StoreInt32(&i.paused, 0)
+	return map[string]interface{}{"success": true}, nil
+}
+
+// isPaused reports whether a "pause" direct method call is still in effect.
+func (i *Iothub) isPaused() bool {
+	return atomic.LoadInt32(&i.paused) == 1
+}
+
+// startDesiredPropertySync subscribes to module twin desired-property
+// updates and applies supported reconfiguration under controlMu so Write()
+// never observes a half-applied change.
+func (i *Iothub) startDesiredPropertySync() {
+	ctx, cancel := context.WithCancel(context.Background())
+	i.control.cancel = cancel
+
+	updates, err := i.Client.SubscribeTwinUpdates(ctx)
+	if err != nil {
+		i.Log.Errorf("azure_iothub: failed to subscribe to twin updates: %v", err)
+		return
+	}
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case state, ok := <-updates:
+				if !ok {
+					return
+				}
+				i.applyDesiredProperties(state.Desired)
+			}
+		}
+	}()
+}
+
+// applyDesiredProperties mutates the plugin's live configuration in
+// response to a module twin desired-property update. Only flush interval
+// and endpoint routing conditions are reconfigurable today; unrecognized
+// keys are ignored.
+func (i *Iothub) applyDesiredProperties(desired map[string]interface{}) {
+	i.controlMu.Lock()
+	defer i.controlMu.Unlock()
+
+	if v, ok := desired["flush_interval"].(string); ok {
+		if d, err := time.ParseDuration(v); err == nil {
+			i.FileUploadFlushInterval = config.Duration(d)
+			if i.fileUpload.cancel != nil {
+				i.fileUpload.cancel()
+				i.startFileUploadFlusher()
+			}
+		}
+	}
+
+	routes, ok := desired["endpoint_routing"].(map[string]interface{})
+	if !ok {
+		return
+	}
+
+	for idx := range i.Endpoints {
+		ep := &i.Endpoints[idx]
+		cond, ok := routes[ep.Name].(string)
+		if !ok {
+			continue
+		}
+		parsed, err := parseRouteCondition(cond)
+		if err != nil {
+			i.Log.Errorf("azure_iothub: ignoring invalid desired route condition for endpoint %q: %v", ep.Name, err)
+			continue
+		}
+		ep.Condition = cond
+		ep.condition = parsed
+	}
+}
@@ -0,0 +1,62 @@
+package azure_iothub
+
+import (
+	"testing"
+
+	"github.com/influxdata/telegraf/testutil"
+)
+
+func TestPauseResume(t *testing.T) {
+	i := &Iothub{}
+
+	if i.isPaused() {
+		t.Fatal("isPaused() = true before any pause method call")
+	}
+
+	if _, err := i.handlePauseMethod(nil); err != nil {
+		t.Fatalf("handlePauseMethod() error = %v", err)
+	}
+	if !i.isPaused() {
+		t.Error("isPaused() = false after handlePauseMethod")
+	}
+
+	if _, err := i.handleResumeMethod(nil); err != nil {
+		t.Fatalf("handleResumeMethod() error = %v", err)
+	}
+	if i.isPaused() {
+		t.Error("isPaused() = true after handleResumeMethod")
+	}
+}
+
+func TestApplyDesiredPropertiesEndpointRouting(t *testing.T) {
+	i := &Iothub{Endpoints: []Endpoint{{Name: "cold-storage"}, {Name: "hot-path"}}, Log: testutil.Logger{}}
+
+	i.applyDesiredProperties(map[string]interface{}{
+		"endpoint_routing": map[string]interface{}{
+			"cold-storage": "name == 'temperature'",
+			"hot-path":     "name = 'bad syntax'",
+		},
+	})
+
+	if i.Endpoints[0].Condition != "name == 'temperature'" {
+		t.Errorf("cold-storage condition = %q, want rewritten condition", i.Endpoints[0].Condition)
+	}
+	if len(i.Endpoints[0].condition.clauses) == 0 {
+		t.Error("cold-storage compiled condition not set")
+	}
+
+	if i.Endpoints[1].Condition != "" {
+		t.Errorf("hot-path condition = %q, want unchanged (invalid syntax should be ignored)", i.Endpoints[1].Condition)
+	}
+}
+
+func TestApplyDesiredPropertiesIgnoresUnknownKeys(t *testing.T) {
+	i := &Iothub{Endpoints: []Endpoint{{Name: "cold-storage"}}}
+
+	// no endpoint_routing or flush_interval key: should be a no-op, not a panic.
+	i.applyDesiredProperties(map[string]interface{}{"unrelated": "value"})
+
+	if i.Endpoints[0].Condition != "" {
+		t.Errorf("condition = %q, want unchanged", i.Endpoints[0].Condition)
+	}
+}
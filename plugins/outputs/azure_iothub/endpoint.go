@@ -0,0 +1,274 @@
+package azure_iothub
+
+// endpoint.go
+//
+// Routing subsystem for `[[outputs.azure_iothub.endpoint]]` blocks.
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	eventhub "github.com/Azure/azure-event-hubs-go/v3"
+	servicebus "github.com/Azure/azure-service-bus-go"
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/influxdata/telegraf"
+)
+
+// Endpoint types supported by the routing subsystem.
+const (
+	EndpointTypeEventHub         = "eventhub"
+	EndpointTypeServiceBusQueue  = "servicebus_queue"
+	EndpointTypeServiceBusTopic  = "servicebus_topic"
+	EndpointTypeStorageContainer = "storage_container"
+)
+
+// Endpoint is a single named routing target declared as
+// `[[outputs.azure_iothub.endpoint]]`.
+type Endpoint struct {
+	Name             string `toml:"name"`
+	Type             string `toml:"type"`
+	ConnectionString string `toml:"connection_string"`
+	EventHubName     string `toml:"event_hub"`
+	Queue            string `toml:"queue"`
+	Topic            string `toml:"topic"`
+	ContainerName    string `toml:"container_name"`
+	Condition        string `toml:"condition"`
+
+	condition routeCondition
+	sender    endpointSender
+}
+
+// endpointSender is satisfied by every concrete downstream client the
+// routing subsystem can dispatch a serialized batch to.
+type endpointSender interface {
+	Send(ctx context.Context, payload []byte, properties map[string]string) error
+	Close(ctx context.Context) error
+}
+
+// init resolves the endpoint's downstream client and compiles its route
+// condition. Called once from Iothub.Init().
+func (e *Endpoint) init() error {
+	cond, err := parseRouteCondition(e.Condition)
+	if err != nil {
+		return fmt.Errorf("endpoint %q: %w", e.Name, err)
+	}
+	e.condition = cond
+
+	switch strings.ToLower(e.Type) {
+	case EndpointTypeEventHub:
+		hub, err := eventhub.NewHubFromConnectionString(e.ConnectionString)
+		if err != nil {
+			return fmt.Errorf("endpoint %q: %w", e.Name, err)
+		}
+		e.sender = &eventHubSender{hub: hub}
+	case EndpointTypeServiceBusQueue:
+		entity, err := newServiceBusQueue(e.ConnectionString, e.Queue)
+		if err != nil {
+			return fmt.Errorf("endpoint %q: %w", e.Name, err)
+		}
+		e.sender = &serviceBusSender{entity: entity}
+	case EndpointTypeServiceBusTopic:
+		entity, err := newServiceBusTopic(e.ConnectionString, e.Topic)
+		if err != nil {
+			return fmt.Errorf("endpoint %q: %w", e.Name, err)
+		}
+		e.sender = &serviceBusSender{entity: entity}
+	case EndpointTypeStorageContainer:
+		containerURL, err := newStorageContainerURL(e.ConnectionString, e.ContainerName)
+		if err != nil {
+			return fmt.Errorf("endpoint %q: %w", e.Name, err)
+		}
+		e.sender = &storageContainerSender{containerURL: containerURL}
+	default:
+		return fmt.Errorf("endpoint %q: unsupported type %q", e.Name, e.Type)
+	}
+
+	return nil
+}
+
+type eventHubSender struct {
+	hub *eventhub.Hub
+}
+
+func (s *eventHubSender) Send(ctx context.Context, payload []byte, properties map[string]string) error {
+	ev := eventhub.NewEvent(payload)
+	for k, v := range properties {
+		ev.Set(k, v)
+	}
+	return s.hub.Send(ctx, ev)
+}
+
+func (s *eventHubSender) Close(ctx context.Context) error {
+	return s.hub.Close(ctx)
+}
+
+// serviceBusEntity is implemented by both *servicebus.Queue and
+// *servicebus.Topic, letting a single sender handle either.
+type serviceBusEntity interface {
+	Send(ctx context.Context, msg *servicebus.Message, opts ...servicebus.SendOption) error
+	Close(ctx context.Context) error
+}
+
+func newServiceBusQueue(connectionString, queue string) (serviceBusEntity, error) {
+	ns, err := servicebus.NewNamespace(servicebus.NamespaceWithConnectionString(connectionString))
+	if err != nil {
+		return nil, err
+	}
+	return ns.NewQueue(queue)
+}
+
+func newServiceBusTopic(connectionString, topic string) (serviceBusEntity, error) {
+	ns, err := servicebus.NewNamespace(servicebus.NamespaceWithConnectionString(connectionString))
+	if err != nil {
+		return nil, err
+	}
+	return ns.NewTopic(topic)
+}
+
+type serviceBusSender struct {
+	entity serviceBusEntity
+}
+
+func (s *serviceBusSender) Send(ctx context.Context, payload []byte, properties map[string]string) error {
+	msg := servicebus.NewMessage(payload)
+	if len(properties) > 0 {
+		msg.UserProperties = make(map[string]interface{}, len(properties))
+		for k, v := range properties {
+			msg.UserProperties[k] = v
+		}
+	}
+	return s.entity.Send(ctx, msg)
+}
+
+func (s *serviceBusSender) Close(ctx context.Context) error {
+	return s.entity.Close(ctx)
+}
+
+func newStorageContainerURL(connectionString, container string) (azblob.ContainerURL, error) {
+	accountName, accountKey, err := parseStorageAccountCredentials(connectionString)
+	if err != nil {
+		return azblob.ContainerURL{}, err
+	}
+
+	cred, err := azblob.NewSharedKeyCredential(accountName, accountKey)
+	if err != nil {
+		return azblob.ContainerURL{}, err
+	}
+
+	u, err := url.Parse(fmt.Sprintf("https://%s.blob.core.windows.net/%s", accountName, container))
+	if err != nil {
+		return azblob.ContainerURL{}, err
+	}
+
+	return azblob.NewContainerURL(*u, azblob.NewPipeline(cred, azblob.PipelineOptions{})), nil
+}
+
+func parseStorageAccountCredentials(connectionString string) (accountName, accountKey string, err error) {
+	for _, part := range strings.Split(connectionString, ";") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "AccountName":
+			accountName = kv[1]
+		case "AccountKey":
+			accountKey = kv[1]
+		}
+	}
+
+	if accountName == "" || accountKey == "" {
+		return "", "", fmt.Errorf("storage connection string missing AccountName/AccountKey")
+	}
+
+	return accountName, accountKey, nil
+}
+
+type storageContainerSender struct {
+	containerURL azblob.ContainerURL
+}
+
+func (s *storageContainerSender) Send(ctx context.Context, payload []byte, properties map[string]string) error {
+	blobURL := s.containerURL.NewBlockBlobURL(fmt.Sprintf("telegraf-%d.json", time.Now().UnixNano()))
+	opts := azblob.UploadToBlockBlobOptions{}
+	if len(properties) > 0 {
+		opts.Metadata = azblob.Metadata(properties)
+	}
+	_, err := azblob.UploadBufferToBlockBlob(ctx, payload, blobURL, opts)
+	return err
+}
+
+func (s *storageContainerSender) Close(_ context.Context) error {
+	return nil
+}
+
+// routeCondition is a compiled `condition` expression, e.g.
+// `name == 'temperature' && tag.location == 'room1'`.
+type routeCondition struct {
+	clauses []routeClause
+}
+
+type routeClause struct {
+	field string
+	isTag bool
+	value string
+}
+
+func parseRouteCondition(expr string) (routeCondition, error) {
+	expr = strings.TrimSpace(expr)
+	if expr == "" {
+		return routeCondition{}, nil
+	}
+
+	var clauses []routeClause
+	for _, part := range strings.Split(expr, "&&") {
+		part = strings.TrimSpace(part)
+
+		pieces := strings.SplitN(part, "==", 2)
+		if len(pieces) != 2 {
+			return routeCondition{}, fmt.Errorf("invalid condition clause %q", part)
+		}
+
+		field := strings.TrimSpace(pieces[0])
+		value := strings.Trim(strings.TrimSpace(pieces[1]), `'"`)
+
+		switch {
+		case field == "name":
+			clauses = append(clauses, routeClause{field: "name", value: value})
+		case strings.HasPrefix(field, "tag."):
+			clauses = append(clauses, routeClause{field: strings.TrimPrefix(field, "tag."), isTag: true, value: value})
+		default:
+			return routeCondition{}, fmt.Errorf("unsupported condition field %q", field)
+		}
+	}
+
+	return routeCondition{clauses: clauses}, nil
+}
+
+// matches reports whether every clause holds for the given metric. A
+// condition with no clauses never matches, so an endpoint declared without a
+// `condition` receives no metrics; unmatched metrics go to the default IoT
+// Hub telemetry path instead.
+func (c routeCondition) matches(m telegraf.Metric) bool {
+	if len(c.clauses) == 0 {
+		return false
+	}
+
+	for _, clause := range c.clauses {
+		if clause.isTag {
+			v, ok := m.GetTag(clause.field)
+			if !ok || v != clause.value {
+				return false
+			}
+			continue
+		}
+		if m.Name() != clause.value {
+			return false
+		}
+	}
+
+	return true
+}
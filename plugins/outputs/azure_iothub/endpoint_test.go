@@ -0,0 +1,69 @@
+package azure_iothub
+
+import (
+	"testing"
+	"time"
+
+	"github.com/influxdata/telegraf/metric"
+)
+
+func TestParseRouteCondition(t *testing.T) {
+	tests := []struct {
+		name    string
+		expr    string
+		wantErr bool
+	}{
+		{name: "empty", expr: ""},
+		{name: "name equality", expr: "name == 'temperature'"},
+		{name: "tag equality", expr: "tag.location == 'room1'"},
+		{name: "combined", expr: "name == 'temperature' && tag.location == 'room1'"},
+		{name: "missing operator", expr: "name = 'temperature'", wantErr: true},
+		{name: "unsupported field", expr: "field == 'x'", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseRouteCondition(tt.expr)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseRouteCondition(%q) error = %v, wantErr %v", tt.expr, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRouteConditionMatches(t *testing.T) {
+	m, err := metric.New(
+		"temperature",
+		map[string]string{"location": "room1"},
+		map[string]interface{}{"value": 21.5},
+		time.Unix(0, 0),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error creating metric: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		expr string
+		want bool
+	}{
+		{name: "empty condition never matches", expr: "", want: false},
+		{name: "name matches", expr: "name == 'temperature'", want: true},
+		{name: "name mismatch", expr: "name == 'humidity'", want: false},
+		{name: "tag matches", expr: "tag.location == 'room1'", want: true},
+		{name: "tag mismatch", expr: "tag.location == 'room2'", want: false},
+		{name: "combined requires all clauses", expr: "name == 'temperature' && tag.location == 'room2'", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cond, err := parseRouteCondition(tt.expr)
+			if err != nil {
+				t.Fatalf("parseRouteCondition(%q): %v", tt.expr, err)
+			}
+			if got := cond.matches(m); got != tt.want {
+				t.Errorf("condition %q matches() = %v, want %v", tt.expr, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,158 @@
+package azure_iothub
+
+// enrichment.go
+//
+// Message enrichment support for `[[outputs.azure_iothub.enrichment]]` blocks.
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+// twinRefreshInterval controls how often module twin tags referenced by
+// `{iothub.twin.tag.<name>}` tokens are refreshed in the background.
+const twinRefreshInterval = 5 * time.Minute
+
+// Enrichment is a single `[[outputs.azure_iothub.enrichment]]` block.
+type Enrichment struct {
+	Key                string   `toml:"key"`
+	Value              string   `toml:"value"`
+	AppliesToEndpoints []string `toml:"applies_to_endpoints"`
+}
+
+// appliesTo reports whether this enrichment should be attached to a message
+// bound for the named endpoint ("" denotes the default telemetry path). An
+// enrichment with no applies_to_endpoints applies everywhere.
+func (e Enrichment) appliesTo(endpoint string) bool {
+	if len(e.AppliesToEndpoints) == 0 {
+		return true
+	}
+
+	for _, name := range e.AppliesToEndpoints {
+		if name == endpoint {
+			return true
+		}
+	}
+
+	return false
+}
+
+// usesTwinTag reports whether the enrichment's value references a
+// `{iothub.twin.tag.<name>}` substitution token.
+func (e Enrichment) usesTwinTag() bool {
+	return strings.Contains(e.Value, "{iothub.twin.tag.")
+}
+
+// twinCache holds module twin tag values, refreshed periodically in the
+// background so Write() never blocks on a twin round-trip.
+type twinCache struct {
+	mu     sync.RWMutex
+	tags   map[string]string
+	cancel context.CancelFunc
+}
+
+func (t *twinCache) get(name string) string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.tags[name]
+}
+
+func (t *twinCache) set(tags map[string]string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.tags = tags
+}
+
+// needsTwinSync reports whether any configured enrichment reads a module
+// twin tag, in which case the background refresh goroutine is worth
+// running.
+func (i *Iothub) needsTwinSync() bool {
+	for _, e := range i.Enrichments {
+		if e.usesTwinTag() {
+			return true
+		}
+	}
+	return false
+}
+
+// startTwinSync retrieves the module twin immediately, then refreshes it on
+// twinRefreshInterval until Close() calls the cancel func stored on i.twin.
+func (i *Iothub) startTwinSync() {
+	ctx, cancel := context.WithCancel(context.Background())
+	i.twin.cancel = cancel
+
+	i.refreshTwin(ctx)
+
+	go func() {
+		ticker := time.NewTicker(twinRefreshInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				i.refreshTwin(ctx)
+			}
+		}
+	}()
+}
+
+func (i *Iothub) refreshTwin(ctx context.Context) {
+	state, err := i.Client.RetrieveTwinState(ctx)
+	if err != nil {
+		// the next tick will retry; stale tags are preferable to blocking Write()
+		return
+	}
+	i.twin.set(state.Tags)
+}
+
+// resolveEnrichmentValue expands the substitution tokens supported in an
+// enrichment `value`.
+func (i *Iothub) resolveEnrichmentValue(value string) string {
+	v := value
+	v = strings.ReplaceAll(v, "{device_id}", i.DeviceID)
+	v = strings.ReplaceAll(v, "{module_id}", i.ModuleID)
+	v = strings.ReplaceAll(v, "{hub_name}", i.HubName)
+
+	for {
+		start := strings.Index(v, "{iothub.twin.tag.")
+		if start < 0 {
+			break
+		}
+		end := strings.Index(v[start:], "}")
+		if end < 0 {
+			break
+		}
+		end += start
+
+		name := v[start+len("{iothub.twin.tag.") : end]
+		v = v[:start] + i.twin.get(name) + v[end+1:]
+	}
+
+	return v
+}
+
+// enrichmentProperties builds the application properties to attach to a
+// message bound for the named endpoint ("" denotes the default telemetry
+// path), or nil if no enrichment applies.
+func (i *Iothub) enrichmentProperties(endpoint string) map[string]string {
+	if len(i.Enrichments) == 0 {
+		return nil
+	}
+
+	var props map[string]string
+	for _, e := range i.Enrichments {
+		if !e.appliesTo(endpoint) {
+			continue
+		}
+		if props == nil {
+			props = make(map[string]string)
+		}
+		props[e.Key] = i.resolveEnrichmentValue(e.Value)
+	}
+
+	return props
+}
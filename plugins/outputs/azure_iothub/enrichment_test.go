@@ -0,0 +1,58 @@
+package azure_iothub
+
+import "testing"
+
+func TestResolveEnrichmentValue(t *testing.T) {
+	i := &Iothub{HubName: "myhub", DeviceID: "dev1", ModuleID: "mod1"}
+	i.twin.set(map[string]string{"location": "room1"})
+
+	tests := []struct {
+		name  string
+		value string
+		want  string
+	}{
+		{name: "literal", value: "static-value", want: "static-value"},
+		{name: "device id", value: "{device_id}", want: "dev1"},
+		{name: "module id", value: "{module_id}", want: "mod1"},
+		{name: "hub name", value: "{hub_name}", want: "myhub"},
+		{name: "twin tag", value: "{iothub.twin.tag.location}", want: "room1"},
+		{name: "unknown twin tag resolves empty", value: "{iothub.twin.tag.missing}", want: ""},
+		{name: "combined tokens", value: "{hub_name}/{device_id}/{module_id}", want: "myhub/dev1/mod1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := i.resolveEnrichmentValue(tt.value); got != tt.want {
+				t.Errorf("resolveEnrichmentValue(%q) = %q, want %q", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEnrichmentProperties(t *testing.T) {
+	i := &Iothub{DeviceID: "dev1"}
+	i.Enrichments = []Enrichment{
+		{Key: "iothub-connection-device-id", Value: "{device_id}"},
+		{Key: "cold-storage-only", Value: "yes", AppliesToEndpoints: []string{"cold-storage"}},
+	}
+
+	def := i.enrichmentProperties("")
+	if def["iothub-connection-device-id"] != "dev1" {
+		t.Errorf("expected default endpoint to get the global enrichment, got %#v", def)
+	}
+	if _, ok := def["cold-storage-only"]; ok {
+		t.Errorf("default endpoint should not get an enrichment scoped to cold-storage, got %#v", def)
+	}
+
+	scoped := i.enrichmentProperties("cold-storage")
+	if scoped["cold-storage-only"] != "yes" {
+		t.Errorf("expected cold-storage endpoint to get its scoped enrichment, got %#v", scoped)
+	}
+}
+
+func TestEnrichmentPropertiesNilWhenUnconfigured(t *testing.T) {
+	i := &Iothub{}
+	if props := i.enrichmentProperties(""); props != nil {
+		t.Errorf("expected nil properties with no enrichments configured, got %#v", props)
+	}
+}
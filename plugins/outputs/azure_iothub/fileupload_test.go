@@ -0,0 +1,44 @@
+package azure_iothub
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"testing"
+)
+
+func TestGzipCompress(t *testing.T) {
+	payload := []byte(`{"fields":{"value":1},"name":"temperature"}`)
+
+	compressed, err := gzipCompress(payload)
+	if err != nil {
+		t.Fatalf("gzipCompress() error = %v", err)
+	}
+
+	r, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		t.Fatalf("gzip.NewReader() error = %v", err)
+	}
+	defer r.Close()
+
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("reading decompressed payload: %v", err)
+	}
+
+	if !bytes.Equal(got, payload) {
+		t.Errorf("round-tripped payload = %q, want %q", got, payload)
+	}
+}
+
+func TestFileUploadMaxBytes(t *testing.T) {
+	i := &Iothub{}
+	if got := i.fileUploadMaxBytes(); got != defaultFileUploadMaxBytes {
+		t.Errorf("fileUploadMaxBytes() with no override = %d, want default %d", got, defaultFileUploadMaxBytes)
+	}
+
+	i.FileUploadMaxBytes = 1024
+	if got := i.fileUploadMaxBytes(); got != 1024 {
+		t.Errorf("fileUploadMaxBytes() = %d, want 1024", got)
+	}
+}